@@ -1,20 +1,35 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/ghodss/yaml"
 	"github.com/go-openapi/spec"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/linkerd/linkerd2/controller/api/util"
 	sp "github.com/linkerd/linkerd2/controller/gen/apis/serviceprofile/v1alpha1"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/linkerd/linkerd2/pkg/k8s"
 	"github.com/linkerd/linkerd2/pkg/profiles"
 	"github.com/spf13/cobra"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/validation"
 )
@@ -29,18 +44,28 @@ type templateConfig struct {
 var pathParamRegex = regexp.MustCompile(`\\{[^\}]*\\}`)
 
 type profileOptions struct {
-	name      string
-	namespace string
-	template  bool
-	openAPI   string
+	name             string
+	namespace        string
+	template         bool
+	openAPI          string
+	tap              bool
+	tapDuration      time.Duration
+	tapRouteLimit    int
+	proto            string
+	protoImportPaths []string
+	output           string
 }
 
 func newProfileOptions() *profileOptions {
 	return &profileOptions{
-		name:      "",
-		namespace: "default",
-		template:  false,
-		openAPI:   "",
+		name:          "",
+		namespace:     "default",
+		template:      false,
+		openAPI:       "",
+		tap:           false,
+		tapDuration:   30 * time.Second,
+		tapRouteLimit: 0,
+		proto:         "",
 	}
 }
 
@@ -52,8 +77,14 @@ func (options *profileOptions) validate() error {
 	if options.openAPI != "" {
 		outputs++
 	}
+	if options.tap {
+		outputs++
+	}
+	if options.proto != "" {
+		outputs++
+	}
 	if outputs != 1 {
-		return errors.New("You must specify exactly one of --template or --open-api")
+		return errors.New("You must specify exactly one of --template, --open-api, --tap, or --proto")
 	}
 
 	// a DNS-1035 label must consist of lower case alphanumeric characters or '-',
@@ -76,7 +107,7 @@ func newCmdProfile() *cobra.Command {
 	options := newProfileOptions()
 
 	cmd := &cobra.Command{
-		Use:   "profile [flags] (--template | --open-api file) (SERVICE)",
+		Use:   "profile [flags] (--template | --open-api file | --tap | --proto file) (SERVICE)",
 		Short: "Output service profile config for Kubernetes",
 		Long: `Output service profile config for Kubernetes.
 
@@ -92,10 +123,38 @@ Example:
   kubectl apply -f web-svc-profile.yaml
 
 If the --open-api flag is specified, it reads the given OpenAPI
-specification file and outputs a corresponding service profile.
+specification file (Swagger 2.0 or OpenAPI 3.x) and outputs a corresponding
+service profile. The spec may also be fetched directly from an in-cluster
+service, by passing a reference of the form
+svc://<namespace>/<service>:<port><path> in place of a file path; if <path>
+is omitted, /swagger.json and then /openapi.json are tried. If the spec
+declares operations under more than one tag, passing -o multi emits one
+service profile per tag
+(named <tag>.<namespace>.svc.cluster.local) separated by "---", instead of
+a single profile for SERVICE; operations with no tag still go into a profile
+for SERVICE.
+
+Example:
+  linkerd profile -n emojivoto --open-api web-svc.swagger web-svc | kubectl apply -f -
+  linkerd profile -n emojivoto --open-api svc://emojivoto/web:80/swagger.json web-svc | kubectl apply -f -
+  linkerd profile -n emojivoto --open-api web-svc.openapi3.yml -o multi web-svc | kubectl apply -f -
+
+If the --tap flag is specified, it taps live traffic to the given service for
+--tap-duration (or until --tap-requests observations have been collected,
+whichever comes first), and derives a service profile from the request paths
+and response codes it observes. This is useful for bootstrapping a profile for
+a service that doesn't have an OpenAPI spec on hand.
+
+Example:
+  linkerd profile -n emojivoto --tap web-svc --tap-duration 10s | kubectl apply -f -
+
+If the --proto flag is specified, it reads the given protobuf descriptor and
+outputs a corresponding service profile, with one route per gRPC method. Use
+--proto-import-path (repeatable) to resolve imports within the proto file;
+it defaults to the proto file's own directory.
 
 Example:
-  linkerd profile -n emojivoto --open-api web-svc.swagger web-svc | kubectl apply -f -`,
+  linkerd profile -n emojivoto --proto web-svc.proto web-svc | kubectl apply -f -`,
 		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			options.name = args[0]
@@ -109,6 +168,10 @@ Example:
 				return profiles.RenderProfileTemplate(options.namespace, options.name, controlPlaneNamespace, os.Stdout)
 			} else if options.openAPI != "" {
 				return renderOpenAPI(options, os.Stdout)
+			} else if options.tap {
+				return renderTap(options, os.Stdout)
+			} else if options.proto != "" {
+				return renderProto(options, os.Stdout)
 			}
 
 			// we should never get here
@@ -118,50 +181,147 @@ Example:
 
 	cmd.PersistentFlags().BoolVar(&options.template, "template", options.template, "Output a service profile template")
 	cmd.PersistentFlags().StringVar(&options.openAPI, "open-api", options.openAPI, "Output a service profile based on the given OpenAPI spec file")
+	cmd.PersistentFlags().BoolVar(&options.tap, "tap", options.tap, "Output a service profile based on tapped live traffic")
+	cmd.PersistentFlags().DurationVar(&options.tapDuration, "tap-duration", options.tapDuration, "Duration over which tapped requests are collected")
+	cmd.PersistentFlags().IntVar(&options.tapRouteLimit, "tap-requests", options.tapRouteLimit, "Maximum number of tapped requests to collect, regardless of --tap-duration (default unlimited)")
+	cmd.PersistentFlags().StringVar(&options.proto, "proto", options.proto, "Output a service profile based on the given protobuf descriptor")
+	cmd.PersistentFlags().StringArrayVar(&options.protoImportPaths, "proto-import-path", options.protoImportPaths, "Directory to search for imports referenced by the --proto file (may be repeated)")
 	cmd.PersistentFlags().StringVarP(&options.namespace, "namespace", "n", options.namespace, "Namespace of the service")
+	cmd.PersistentFlags().StringVarP(&options.output, "output", "o", options.output, "Output format; \"multi\" splits a tagged --open-api spec into one service profile per tag")
 
 	return cmd
 }
 
-func renderOpenAPI(options *profileOptions, w io.Writer) error {
-	var input io.Reader
-	if options.openAPI == "-" {
-		input = os.Stdin
-	} else {
-		var err error
-		input, err = os.Open(options.openAPI)
-		if err != nil {
-			return err
+// svcReferenceRegex matches an in-cluster service reference of the form
+// svc://<namespace>/<service>:<port><path>, e.g.
+// svc://emojivoto/web:80/swagger.json. <path> is optional; when omitted,
+// fetchServiceOpenAPI tries /swagger.json and then /openapi.json.
+var svcReferenceRegex = regexp.MustCompile(`^svc://(?P<namespace>[^/]+)/(?P<service>[^:/]+):(?P<port>[0-9]+)(?P<path>/.*)?$`)
+
+// defaultOpenAPIPaths are tried in order when a svc:// reference omits a path.
+var defaultOpenAPIPaths = []string{"/swagger.json", "/openapi.json"}
+
+// openAPIInput resolves the --open-api flag to a readable stream: "-" reads
+// stdin, a svc:// reference fetches the spec from the referenced in-cluster
+// service, and anything else is treated as a local file path.
+func openAPIInput(openAPI string) (io.Reader, error) {
+	switch {
+	case openAPI == "-":
+		return os.Stdin, nil
+	case svcReferenceRegex.MatchString(openAPI):
+		return fetchServiceOpenAPI(openAPI)
+	default:
+		return os.Open(openAPI)
+	}
+}
+
+// fetchServiceOpenAPI fetches the OpenAPI spec at ref (a svc:// reference)
+// by proxying the request through the Kubernetes API server, the same way
+// `kubectl get --raw` reaches a service without requiring a port-forward. It
+// uses the same kubeconfigPath/kubeContext-driven client factory as the rest
+// of the linkerd CLI, so --kubeconfig/--context are honored here too.
+func fetchServiceOpenAPI(ref string) (io.Reader, error) {
+	match := svcReferenceRegex.FindStringSubmatch(ref)
+	if match == nil {
+		return nil, fmt.Errorf("invalid --open-api service reference %q, expected svc://<namespace>/<service>:<port><path>", ref)
+	}
+	namespace, service, port, path := match[1], match[2], match[3], match[4]
+
+	paths := defaultOpenAPIPaths
+	if path != "" {
+		paths = []string{path}
+	}
+
+	api, err := k8s.NewAPI(kubeconfigPath, kubeContext, "", nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Error configuring Kubernetes client: %s", err)
+	}
+
+	var lastErr error
+	for _, p := range paths {
+		body, err := api.CoreV1().RESTClient().Get().
+			Namespace(namespace).
+			Resource("services").
+			Name(fmt.Sprintf("%s:%s", service, port)).
+			SubResource("proxy").
+			Suffix(p).
+			DoRaw(context.Background())
+		if err == nil {
+			return bytes.NewReader(body), nil
 		}
+		lastErr = err
 	}
 
-	bytes, err := ioutil.ReadAll(input)
+	return nil, fmt.Errorf("Error fetching %s: %s", ref, lastErr)
+}
+
+// parsedPath is a single operation extracted from an OpenAPI document,
+// normalized across the v2 (Swagger) and v3 shapes so that route generation
+// only has to be written once.
+type parsedPath struct {
+	path      string
+	pathRegex string
+	method    string
+	tags      []string
+	statuses  []int
+}
+
+// parsedAPI is the document-version-agnostic result of parsing an OpenAPI
+// spec: the set of operations it declares, in path order.
+type parsedAPI struct {
+	paths []parsedPath
+}
+
+func renderOpenAPI(options *profileOptions, w io.Writer) error {
+	input, err := openAPIInput(options.openAPI)
+	if err != nil {
+		return err
+	}
+
+	raw, err := ioutil.ReadAll(input)
 	if err != nil {
 		return fmt.Errorf("Error reading file: %s", err)
 	}
-	json, err := yaml.YAMLToJSON(bytes)
+	doc, err := yaml.YAMLToJSON(raw)
 	if err != nil {
 		return fmt.Errorf("Error parsing yaml: %s", err)
 	}
 
-	swagger := spec.Swagger{}
-	err = swagger.UnmarshalJSON(json)
+	api, err := parseOpenAPI(doc)
 	if err != nil {
 		return fmt.Errorf("Error parsing OpenAPI spec: %s", err)
 	}
 
-	profile := sp.ServiceProfile{
-		ObjectMeta: meta_v1.ObjectMeta{
-			Name:      fmt.Sprintf("%s.%s.svc.cluster.local", options.name, options.namespace),
-			Namespace: controlPlaneNamespace,
-		},
-		TypeMeta: meta_v1.TypeMeta{
-			APIVersion: "linkerd.io/v1alpha1",
-			Kind:       "ServiceProfile",
-		},
+	return renderAPIProfiles(options, api, w)
+}
+
+// parseOpenAPI detects whether doc is a Swagger 2.0 or OpenAPI 3.x document
+// and routes it through the matching parser.
+func parseOpenAPI(doc []byte) (*parsedAPI, error) {
+	var version struct {
+		Swagger string `json:"swagger"`
+		OpenAPI string `json:"openapi"`
+	}
+	if err := json.Unmarshal(doc, &version); err != nil {
+		return nil, err
 	}
 
-	routes := make([]*sp.RouteSpec, 0)
+	switch {
+	case strings.HasPrefix(version.Swagger, "2."):
+		return parseSwagger2(doc)
+	case strings.HasPrefix(version.OpenAPI, "3."):
+		return parseOpenAPI3(doc)
+	default:
+		return nil, fmt.Errorf("unrecognized OpenAPI document: expected a \"swagger: 2.x\" or \"openapi: 3.x\" version field")
+	}
+}
+
+// parseSwagger2 extracts a parsedAPI from a Swagger 2.0 document.
+func parseSwagger2(doc []byte) (*parsedAPI, error) {
+	swagger := spec.Swagger{}
+	if err := swagger.UnmarshalJSON(doc); err != nil {
+		return nil, err
+	}
 
 	paths := make([]string, 0)
 	if swagger.Paths != nil {
@@ -171,54 +331,181 @@ func renderOpenAPI(options *profileOptions, w io.Writer) error {
 		sort.Strings(paths)
 	}
 
+	api := &parsedAPI{}
 	for _, path := range paths {
 		item := swagger.Paths.Paths[path]
 		pathRegex := pathToRegex(path)
-		if item.Delete != nil {
-			spec := mkRouteSpec(path, pathRegex, http.MethodDelete, item.Delete.Responses)
-			routes = append(routes, spec)
+		for _, op := range []struct {
+			method string
+			op     *spec.Operation
+		}{
+			{http.MethodDelete, item.Delete},
+			{http.MethodGet, item.Get},
+			{http.MethodHead, item.Head},
+			{http.MethodOptions, item.Options},
+			{http.MethodPatch, item.Patch},
+			{http.MethodPost, item.Post},
+			{http.MethodPut, item.Put},
+		} {
+			if op.op == nil {
+				continue
+			}
+			api.paths = append(api.paths, parsedPath{
+				path:      path,
+				pathRegex: pathRegex,
+				method:    op.method,
+				tags:      op.op.Tags,
+				statuses:  swagger2Statuses(op.op.Responses),
+			})
 		}
-		if item.Get != nil {
-			spec := mkRouteSpec(path, pathRegex, http.MethodGet, item.Get.Responses)
-			routes = append(routes, spec)
+	}
+	return api, nil
+}
+
+func swagger2Statuses(responses *spec.Responses) []int {
+	if responses == nil {
+		return nil
+	}
+	statuses := make([]int, 0, len(responses.StatusCodeResponses))
+	for status := range responses.StatusCodeResponses {
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// parseOpenAPI3 extracts a parsedAPI from an OpenAPI 3.x document. Paths and
+// Responses are both the map-backed struct types kin-openapi has used since
+// v0.1.0: list their entries via Map() rather than ranging over them
+// directly.
+func parseOpenAPI3(doc []byte) (*parsedAPI, error) {
+	document, err := openapi3.NewLoader().LoadFromData(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	pathItems := document.Paths.Map()
+	paths := make([]string, 0, len(pathItems))
+	for path := range pathItems {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	api := &parsedAPI{}
+	for _, path := range paths {
+		item := pathItems[path]
+		pathRegex := pathToRegex(path)
+
+		operations := item.Operations()
+		methods := make([]string, 0, len(operations))
+		for method := range operations {
+			methods = append(methods, method)
 		}
-		if item.Head != nil {
-			spec := mkRouteSpec(path, pathRegex, http.MethodHead, item.Head.Responses)
-			routes = append(routes, spec)
+		sort.Strings(methods)
+
+		for _, method := range methods {
+			op := operations[method]
+			api.paths = append(api.paths, parsedPath{
+				path:      path,
+				pathRegex: pathRegex,
+				method:    method,
+				tags:      op.Tags,
+				statuses:  openAPI3Statuses(op.Responses),
+			})
 		}
-		if item.Options != nil {
-			spec := mkRouteSpec(path, pathRegex, http.MethodOptions, item.Options.Responses)
-			routes = append(routes, spec)
+	}
+	return api, nil
+}
+
+func openAPI3Statuses(responses *openapi3.Responses) []int {
+	if responses == nil {
+		return nil
+	}
+	byCode := responses.Map()
+	statuses := make([]int, 0, len(byCode))
+	for code := range byCode {
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			// "default" and similar wildcard response codes don't map to a
+			// single status and are skipped.
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// renderAPIProfiles writes api as a single service profile for options.name,
+// unless options.output is "multi", in which case it splits api's operations
+// by OpenAPI tag and writes one `---`-separated ServiceProfile per tag, named
+// "<tag>.<namespace>.svc.cluster.local". This lets a single OpenAPI document
+// covering several logical services, one per tag, produce a profile for each
+// in one invocation. Operations with no tag are written to a profile named
+// after options.name. Operations are not split by `servers`; an operation
+// declaring multiple servers is still emitted once, under its tag(s).
+func renderAPIProfiles(options *profileOptions, api *parsedAPI, w io.Writer) error {
+	if options.output != "multi" {
+		return writeProfile(options.name, options.namespace, api.paths, w)
+	}
+
+	groups := make(map[string][]parsedPath)
+	for _, p := range api.paths {
+		if len(p.tags) == 0 {
+			groups[options.name] = append(groups[options.name], p)
+			continue
 		}
-		if item.Patch != nil {
-			spec := mkRouteSpec(path, pathRegex, http.MethodPatch, item.Patch.Responses)
-			routes = append(routes, spec)
+		for _, tag := range p.tags {
+			groups[tag] = append(groups[tag], p)
 		}
-		if item.Post != nil {
-			spec := mkRouteSpec(path, pathRegex, http.MethodPost, item.Post.Responses)
-			routes = append(routes, spec)
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		if i > 0 {
+			fmt.Fprintln(w, "---")
 		}
-		if item.Put != nil {
-			spec := mkRouteSpec(path, pathRegex, http.MethodPut, item.Put.Responses)
-			routes = append(routes, spec)
+		if err := writeProfile(name, options.namespace, groups[name], w); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
+func writeProfile(name, namespace string, paths []parsedPath, w io.Writer) error {
+	profile := sp.ServiceProfile{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%s.svc.cluster.local", name, namespace),
+			Namespace: controlPlaneNamespace,
+		},
+		TypeMeta: meta_v1.TypeMeta{
+			APIVersion: "linkerd.io/v1alpha1",
+			Kind:       "ServiceProfile",
+		},
+	}
+
+	routes := make([]*sp.RouteSpec, 0, len(paths))
+	for _, p := range paths {
+		routes = append(routes, mkRouteSpec(p))
+	}
 	profile.Spec.Routes = routes
+
 	output, err := yaml.Marshal(profile)
 	if err != nil {
 		return fmt.Errorf("Error writing Service Profile: %s", err)
 	}
-	w.Write(output)
-
-	return nil
+	_, err = w.Write(output)
+	return err
 }
 
-func mkRouteSpec(path, pathRegex string, method string, responses *spec.Responses) *sp.RouteSpec {
+func mkRouteSpec(p parsedPath) *sp.RouteSpec {
 	return &sp.RouteSpec{
-		Name:            fmt.Sprintf("%s %s", method, path),
-		Condition:       toReqMatch(pathRegex, method),
-		ResponseClasses: toRspClasses(responses),
+		Name:            fmt.Sprintf("%s %s", p.method, p.path),
+		Condition:       toReqMatch(p.pathRegex, p.method),
+		ResponseClasses: toRspClasses(p.statuses),
 	}
 }
 
@@ -234,29 +521,560 @@ func toReqMatch(path string, method string) *sp.RequestMatch {
 	}
 }
 
-func toRspClasses(responses *spec.Responses) []*sp.ResponseClass {
-	if responses == nil {
+func toRspClasses(statuses []int) []*sp.ResponseClass {
+	if len(statuses) == 0 {
 		return nil
 	}
-	classes := make([]*sp.ResponseClass, 0)
 
-	statuses := make([]int, 0)
-	for status := range responses.StatusCodeResponses {
-		statuses = append(statuses, status)
+	distinct := make(map[int]bool, len(statuses))
+	for _, status := range statuses {
+		distinct[status] = true
+	}
+	sorted := make([]int, 0, len(distinct))
+	for status := range distinct {
+		sorted = append(sorted, status)
 	}
-	sort.Ints(statuses)
+	sort.Ints(sorted)
 
-	for _, status := range statuses {
-		cond := &sp.ResponseMatch{
-			Status: &sp.Range{
-				Min: uint32(status),
-				Max: uint32(status),
+	classes := make([]*sp.ResponseClass, 0, len(sorted))
+	for _, status := range sorted {
+		classes = append(classes, &sp.ResponseClass{
+			Condition: &sp.ResponseMatch{
+				Status: &sp.Range{
+					Min: uint32(status),
+					Max: uint32(status),
+				},
 			},
+			IsFailure: status >= 500,
+		})
+	}
+	return classes
+}
+
+// tapObservation captures the method, path and response status of a single
+// request/response pair observed while tapping a service.
+type tapObservation struct {
+	method string
+	path   string
+	status int
+}
+
+// highCardinalityCount and highCardinalityRatio bound how many distinct
+// values a path segment may take, relative to the number of requests sharing
+// its parent prefix, before it's considered a path parameter rather than a
+// fixed route segment. The ratio check only applies once a prefix has been
+// observed more than highCardinalityCount times, so two sibling routes each
+// observed once (e.g. GET /authors and GET /books) don't get collapsed into
+// a wildcard just because they happen to be the only two requests seen.
+const (
+	highCardinalityCount = 5
+	highCardinalityRatio = 0.3
+)
+
+// renderTap taps options.name for options.tapDuration (or until
+// options.tapRouteLimit requests have been observed, whichever comes first)
+// and derives a service profile from the request paths and response codes it
+// observes.
+func renderTap(options *profileOptions, w io.Writer) error {
+	observations, err := tapService(options)
+	if err != nil {
+		return fmt.Errorf("Error tapping service %q: %s", options.name, err)
+	}
+
+	profile := sp.ServiceProfile{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%s.svc.cluster.local", options.name, options.namespace),
+			Namespace: controlPlaneNamespace,
+		},
+		TypeMeta: meta_v1.TypeMeta{
+			APIVersion: "linkerd.io/v1alpha1",
+			Kind:       "ServiceProfile",
+		},
+	}
+	profile.Spec.Routes = observationsToRoutes(observations)
+
+	output, err := yaml.Marshal(profile)
+	if err != nil {
+		return fmt.Errorf("Error writing Service Profile: %s", err)
+	}
+	w.Write(output)
+
+	return nil
+}
+
+// tapService opens a long-poll stream to the tap API for the given service,
+// the same streaming pattern used to follow a pod's logs, and buffers
+// observed requests until the window closes.
+func tapService(options *profileOptions) ([]tapObservation, error) {
+	client, err := newPublicAPIClient()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := util.BuildTapByResourceRequest(util.TapRequestParams{
+		Resource:  fmt.Sprintf("svc/%s", options.name),
+		Namespace: options.namespace,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.tapDuration)
+	defer cancel()
+
+	stream, err := client.TapByResource(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	observations := make([]tapObservation, 0)
+	pending := make(map[string]pendingTapRequest)
+	for options.tapRouteLimit <= 0 || len(observations) < options.tapRouteLimit {
+		event, err := stream.Recv()
+		if err == io.EOF || ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if obs, ok := toTapObservation(event, pending); ok {
+			observations = append(observations, obs)
+		}
+	}
+
+	return observations, nil
+}
+
+// pendingTapRequest holds the method and path captured from a RequestInit
+// event until the matching ResponseInit event (correlated by stream id)
+// supplies the response status.
+type pendingTapRequest struct {
+	method string
+	path   string
+}
+
+// tapStreamID returns a map key identifying the HTTP exchange a tap event
+// belongs to, so its RequestInit and ResponseInit events (delivered as
+// separate events on the stream) can be correlated.
+func tapStreamID(id *pb.TapEvent_Http_StreamId) string {
+	if id == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", id.GetBase(), id.GetStream())
+}
+
+// toTapObservation consumes a single tap event. A TapEvent_Http carries
+// exactly one of RequestInit, ResponseInit or ResponseEnd in its event
+// oneof, never more than one, so a request's path/method and its response
+// status arrive as separate events and must be correlated by stream id
+// rather than read off one event: a RequestInit stashes its method and path
+// in pending, keyed by stream id; the matching ResponseInit (which is where
+// the HTTP status code lives — ResponseEnd carries only byte counts/timing)
+// completes the observation. ResponseEnd events, and any ResponseInit with
+// no corresponding pending RequestInit, are ignored.
+func toTapObservation(event *pb.TapEvent, pending map[string]pendingTapRequest) (tapObservation, bool) {
+	httpEvent := event.GetHttp()
+	if httpEvent == nil {
+		return tapObservation{}, false
+	}
+
+	if init := httpEvent.GetRequestInit(); init != nil {
+		pending[tapStreamID(init.GetId())] = pendingTapRequest{
+			method: httpMethodString(init.GetMethod()),
+			path:   init.GetPath(),
+		}
+		return tapObservation{}, false
+	}
+
+	respInit := httpEvent.GetResponseInit()
+	if respInit == nil {
+		return tapObservation{}, false
+	}
+
+	id := tapStreamID(respInit.GetId())
+	req, ok := pending[id]
+	if !ok {
+		return tapObservation{}, false
+	}
+	delete(pending, id)
+
+	return tapObservation{
+		method: req.method,
+		path:   req.path,
+		status: int(respInit.GetHttpStatus()),
+	}, true
+}
+
+// httpMethodString returns the HTTP method name for a tapped request. The
+// tap API represents the method as a oneof of a registered enum value or an
+// arbitrary unregistered verb; querying GetRegistered() directly on an
+// unregistered method silently returns "GET", its zero value, so the
+// Unregistered arm must be checked first.
+func httpMethodString(method *pb.HttpMethod) string {
+	if method == nil {
+		return ""
+	}
+	if unregistered := method.GetUnregistered(); unregistered != "" {
+		return unregistered
+	}
+	return method.GetRegistered().String()
+}
+
+// observationsToRoutes clusters the observed request paths into route
+// templates, grouped by (method, template), and builds the response classes
+// for each from the status codes observed for that template.
+func observationsToRoutes(observations []tapObservation) []*sp.RouteSpec {
+	byMethod := make(map[string][]tapObservation)
+	for _, obs := range observations {
+		byMethod[obs.method] = append(byMethod[obs.method], obs)
+	}
+
+	methods := make([]string, 0, len(byMethod))
+	for method := range byMethod {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	routes := make([]*sp.RouteSpec, 0)
+	for _, method := range methods {
+		for template, obsForTemplate := range clusterByTemplate(byMethod[method]) {
+			statuses := make([]int, len(obsForTemplate))
+			for i, obs := range obsForTemplate {
+				statuses[i] = obs.status
+			}
+
+			routes = append(routes, &sp.RouteSpec{
+				Name:            fmt.Sprintf("%s %s", method, template),
+				Condition:       toReqMatch(template, method),
+				ResponseClasses: toRspClassesFromStatuses(statuses),
+			})
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Name < routes[j].Name })
+	return routes
+}
+
+// pathTrieNode is an intermediate structure used to compute cardinality
+// per-prefix rather than across an entire group of observations: each node
+// represents a path segment reached by some set of requests, keyed by the
+// literal segment values observed at that position given the prefix walked
+// so far.
+type pathTrieNode struct {
+	children map[string]*pathTrieNode
+	obs      []tapObservation
+}
+
+func newPathTrieNode() *pathTrieNode {
+	return &pathTrieNode{children: make(map[string]*pathTrieNode)}
+}
+
+// clusterByTemplate groups observations that share the same number of path
+// segments, then walks a trie built from those paths to decide, one parent
+// prefix at a time, whether the next segment is a fixed route segment or a
+// path parameter. A segment is only collapsed to a `[^/]*` fragment when the
+// requests sharing *that specific prefix* are high cardinality (see
+// isHighCardinality); unrelated sibling routes under a different prefix are
+// judged independently, so low-traffic literal routes don't get merged into
+// one catch-all wildcard just because another branch of the trie is wide.
+func clusterByTemplate(observations []tapObservation) map[string][]tapObservation {
+	bySegmentCount := make(map[int][]tapObservation)
+	for _, obs := range observations {
+		segments := strings.Split(strings.Trim(obs.path, "/"), "/")
+		bySegmentCount[len(segments)] = append(bySegmentCount[len(segments)], obs)
+	}
+
+	byTemplate := make(map[string][]tapObservation)
+	for _, group := range bySegmentCount {
+		root := newPathTrieNode()
+		for _, obs := range group {
+			node := root
+			for _, segment := range strings.Split(strings.Trim(obs.path, "/"), "/") {
+				child, ok := node.children[segment]
+				if !ok {
+					child = newPathTrieNode()
+					node.children[segment] = child
+				}
+				node = child
+			}
+			node.obs = append(node.obs, obs)
+		}
+
+		for template, obsForTemplate := range templatesFromTrie(root, nil) {
+			byTemplate[template] = append(byTemplate[template], obsForTemplate...)
+		}
+	}
+	return byTemplate
+}
+
+// templatesFromTrie recursively walks node, appending a literal or `[^/]*`
+// fragment to prefix at each level depending on whether that level's
+// children are high cardinality, and returns the observations reaching each
+// leaf keyed by the resulting `^...$` template.
+func templatesFromTrie(node *pathTrieNode, prefix []string) map[string][]tapObservation {
+	if len(node.children) == 0 {
+		if len(node.obs) == 0 {
+			return nil
+		}
+		return map[string][]tapObservation{
+			"^/" + strings.Join(prefix, "/") + "$": node.obs,
+		}
+	}
+
+	if isHighCardinality(len(node.children), countObservations(node)) {
+		merged := newPathTrieNode()
+		for _, child := range node.children {
+			mergeTrie(merged, child)
+		}
+		return templatesFromTrie(merged, appendSegment(prefix, "[^/]*"))
+	}
+
+	result := make(map[string][]tapObservation)
+	for segment, child := range node.children {
+		for template, obsForTemplate := range templatesFromTrie(child, appendSegment(prefix, regexp.QuoteMeta(segment))) {
+			result[template] = append(result[template], obsForTemplate...)
+		}
+	}
+	return result
+}
+
+// isHighCardinality reports whether a path segment with the given number of
+// distinct values, observed across total requests sharing its parent
+// prefix, should be treated as a path parameter. The ratio check only kicks
+// in once the prefix has more than highCardinalityCount observations, so a
+// handful of distinct literal routes seen once each aren't mistaken for a
+// high-cardinality parameter.
+func isHighCardinality(distinct, total int) bool {
+	if total <= highCardinalityCount {
+		return false
+	}
+	return distinct > highCardinalityCount || float64(distinct)/float64(total) > highCardinalityRatio
+}
+
+// countObservations sums the observations reachable from node, across all of
+// its descendants.
+func countObservations(node *pathTrieNode) int {
+	total := len(node.obs)
+	for _, child := range node.children {
+		total += countObservations(child)
+	}
+	return total
+}
+
+// mergeTrie merges src into dst in place, used to collapse a node's sibling
+// children into a single `[^/]*` branch once they've been judged high
+// cardinality.
+func mergeTrie(dst, src *pathTrieNode) {
+	dst.obs = append(dst.obs, src.obs...)
+	for segment, child := range src.children {
+		dstChild, ok := dst.children[segment]
+		if !ok {
+			dstChild = newPathTrieNode()
+			dst.children[segment] = dstChild
 		}
+		mergeTrie(dstChild, child)
+	}
+}
+
+// appendSegment returns a copy of prefix with segment appended, so that
+// sibling recursive calls don't share (and corrupt) the same backing array.
+func appendSegment(prefix []string, segment string) []string {
+	next := make([]string, len(prefix)+1)
+	copy(next, prefix)
+	next[len(prefix)] = segment
+	return next
+}
+
+// toRspClassesFromStatuses buckets observed status codes into 1xx/2xx/3xx/
+// 4xx/5xx ranges, marking the 5xx range as a failure, analogous to
+// toRspClasses but for ranges rather than exact codes.
+func toRspClassesFromStatuses(statuses []int) []*sp.ResponseClass {
+	observedRanges := make(map[uint32]bool)
+	for _, status := range statuses {
+		observedRanges[uint32(status/100*100)] = true
+	}
+
+	ranges := make([]uint32, 0, len(observedRanges))
+	for r := range observedRanges {
+		ranges = append(ranges, r)
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i] < ranges[j] })
+
+	classes := make([]*sp.ResponseClass, 0, len(ranges))
+	for _, min := range ranges {
 		classes = append(classes, &sp.ResponseClass{
-			Condition: cond,
-			IsFailure: status >= 500,
+			Condition: &sp.ResponseMatch{
+				Status: &sp.Range{Min: min, Max: min + 99},
+			},
+			IsFailure: min >= 500,
 		})
 	}
 	return classes
 }
+
+// renderProto parses options.proto and emits a service profile with one
+// route per gRPC method declared in it, plus a REST route for any method
+// carrying a google.api.http annotation.
+func renderProto(options *profileOptions, w io.Writer) error {
+	fd, err := parseProtoFile(options.proto, options.protoImportPaths)
+	if err != nil {
+		return fmt.Errorf("Error parsing %s: %s", options.proto, err)
+	}
+
+	profile := sp.ServiceProfile{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%s.svc.cluster.local", options.name, options.namespace),
+			Namespace: controlPlaneNamespace,
+		},
+		TypeMeta: meta_v1.TypeMeta{
+			APIVersion: "linkerd.io/v1alpha1",
+			Kind:       "ServiceProfile",
+		},
+	}
+
+	routes := make([]*sp.RouteSpec, 0)
+	for _, svc := range fd.GetServices() {
+		for _, method := range svc.GetMethods() {
+			routes = append(routes, grpcRouteSpec(svc, method))
+			routes = append(routes, restRouteSpecs(method)...)
+		}
+	}
+
+	profile.Spec.Routes = routes
+	output, err := yaml.Marshal(profile)
+	if err != nil {
+		return fmt.Errorf("Error writing Service Profile: %s", err)
+	}
+	w.Write(output)
+
+	return nil
+}
+
+// parseProtoFile parses protoFile into a file descriptor, resolving its
+// imports against importPaths (protoFile's own directory when importPaths is
+// empty). It uses protoreflect's pure-Go parser rather
+// than shelling out to protoc, so it has no external dependencies at runtime.
+func parseProtoFile(protoFile string, importPaths []string) (*desc.FileDescriptor, error) {
+	if len(importPaths) == 0 {
+		importPaths = []string{filepath.Dir(protoFile)}
+		protoFile = filepath.Base(protoFile)
+	}
+	parser := protoparse.Parser{ImportPaths: importPaths}
+	fds, err := parser.ParseFiles(protoFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(fds) == 0 {
+		return nil, fmt.Errorf("no file descriptor produced for %s", protoFile)
+	}
+	return fds[0], nil
+}
+
+// grpcRouteSpec builds the RouteSpec for a gRPC method, matching the
+// `/<package>.<Service>/<Method>` path that linkerd's proxy sees over HTTP/2.
+//
+// gRPC reports failures via a `grpc-status` trailer on an otherwise-200
+// response, which ResponseMatch has no way to inspect today, so every gRPC
+// response here is classified as a success. Treat this route's response
+// classes as a starting point to hand-edit, not a final answer.
+func grpcRouteSpec(svc *desc.ServiceDescriptor, method *desc.MethodDescriptor) *sp.RouteSpec {
+	path := fmt.Sprintf("/%s/%s", grpcServiceName(svc), method.GetName())
+	return &sp.RouteSpec{
+		Name: path,
+		Condition: &sp.RequestMatch{
+			PathRegex: fmt.Sprintf("^%s$", regexp.QuoteMeta(path)),
+			Method:    http.MethodPost,
+		},
+		ResponseClasses: []*sp.ResponseClass{
+			{
+				Condition: &sp.ResponseMatch{
+					Status: &sp.Range{Min: http.StatusOK, Max: http.StatusOK},
+				},
+				IsFailure: false,
+			},
+		},
+	}
+}
+
+// grpcServiceName renders a method's fully-qualified gRPC service name,
+// omitting the leading "." that a missing/empty proto package would
+// otherwise leave in the path.
+func grpcServiceName(svc *desc.ServiceDescriptor) string {
+	if pkg := svc.GetFile().GetPackage(); pkg != "" {
+		return fmt.Sprintf("%s.%s", pkg, svc.GetName())
+	}
+	return svc.GetName()
+}
+
+// httpAnnotation returns the google.api.http rule attached to method, if any.
+func httpAnnotation(method *desc.MethodDescriptor) (*annotations.HttpRule, bool) {
+	opts := method.GetMethodOptions()
+	if opts == nil || !proto.HasExtension(opts, annotations.E_Http) {
+		return nil, false
+	}
+	rule, ok := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule)
+	if !ok || rule == nil {
+		return nil, false
+	}
+	return rule, true
+}
+
+// restRouteSpecs builds the RouteSpecs for a method's REST mapping(s): the
+// primary google.api.http rule plus any additional_bindings, each of which
+// independently routes to the same RPC. Methods with no http annotation, or
+// a binding with no verb/path set, contribute no routes.
+func restRouteSpecs(method *desc.MethodDescriptor) []*sp.RouteSpec {
+	rule, ok := httpAnnotation(method)
+	if !ok {
+		return nil
+	}
+
+	rules := append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...)
+	routes := make([]*sp.RouteSpec, 0, len(rules))
+	for _, r := range rules {
+		if route, ok := restRouteSpec(r); ok {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// restRouteSpec builds the RouteSpec for a single http rule, converting its
+// path template's `{param}` placeholders through the same pathToRegex logic
+// used for OpenAPI path parameters. It returns false if the rule declares no
+// verb (an empty custom binding, say), since there's no route to build.
+func restRouteSpec(rule *annotations.HttpRule) (*sp.RouteSpec, bool) {
+	method, template, ok := httpRuleMethodAndTemplate(rule)
+	if !ok {
+		return nil, false
+	}
+
+	pathRegex := pathToRegex(template)
+	return &sp.RouteSpec{
+		Name:            fmt.Sprintf("%s %s", method, template),
+		Condition:       toReqMatch(pathRegex, method),
+		ResponseClasses: toRspClassesFromStatuses([]int{http.StatusOK}),
+	}, true
+}
+
+func httpRuleMethodAndTemplate(rule *annotations.HttpRule) (string, string, bool) {
+	switch {
+	case rule.GetGet() != "":
+		return http.MethodGet, rule.GetGet(), true
+	case rule.GetPut() != "":
+		return http.MethodPut, rule.GetPut(), true
+	case rule.GetPost() != "":
+		return http.MethodPost, rule.GetPost(), true
+	case rule.GetDelete() != "":
+		return http.MethodDelete, rule.GetDelete(), true
+	case rule.GetPatch() != "":
+		return http.MethodPatch, rule.GetPatch(), true
+	default:
+		custom := rule.GetCustom()
+		if custom.GetKind() == "" || custom.GetPath() == "" {
+			return "", "", false
+		}
+		return custom.GetKind(), custom.GetPath(), true
+	}
+}
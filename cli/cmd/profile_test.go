@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jhump/protoreflect/desc/protoparse"
+	pb "github.com/linkerd/linkerd2/controller/gen/public"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/api/annotations"
+)
+
+func TestParseOpenAPIVersion(t *testing.T) {
+	swagger2 := []byte(`{"swagger": "2.0", "paths": {"/users": {"get": {"responses": {"200": {}}}}}}`)
+	openapi3 := []byte(`{"openapi": "3.0.0", "paths": {"/users": {"get": {"responses": {"200": {"description": "ok"}}}}}}`)
+	unrecognized := []byte(`{"paths": {}}`)
+
+	api, err := parseOpenAPI(swagger2)
+	require.NoError(t, err)
+	require.Len(t, api.paths, 1)
+	assert.Equal(t, "/users", api.paths[0].path)
+
+	api, err = parseOpenAPI(openapi3)
+	require.NoError(t, err)
+	require.Len(t, api.paths, 1)
+	assert.Equal(t, "/users", api.paths[0].path)
+
+	_, err = parseOpenAPI(unrecognized)
+	assert.Error(t, err)
+}
+
+func TestRenderAPIProfilesMulti(t *testing.T) {
+	api := &parsedAPI{
+		paths: []parsedPath{
+			{path: "/emojis", pathRegex: "^/emojis$", method: "GET", tags: []string{"emoji"}, statuses: []int{200}},
+			{path: "/votes", pathRegex: "^/votes$", method: "POST", tags: []string{"voting"}, statuses: []int{200}},
+		},
+	}
+	options := &profileOptions{name: "web", namespace: "emojivoto", output: "multi"}
+
+	var buf bytes.Buffer
+	err := renderAPIProfiles(options, api, &buf)
+	require.NoError(t, err)
+
+	out := buf.String()
+	assert.Equal(t, 1, strings.Count(out, "---"))
+	assert.Contains(t, out, "name: emoji.emojivoto.svc.cluster.local")
+	assert.Contains(t, out, "name: voting.emojivoto.svc.cluster.local")
+}
+
+func TestRenderAPIProfilesSingle(t *testing.T) {
+	api := &parsedAPI{
+		paths: []parsedPath{
+			{path: "/emojis", pathRegex: "^/emojis$", method: "GET", tags: []string{"emoji"}, statuses: []int{200}},
+		},
+	}
+	options := &profileOptions{name: "web", namespace: "emojivoto"}
+
+	var buf bytes.Buffer
+	err := renderAPIProfiles(options, api, &buf)
+	require.NoError(t, err)
+
+	out := buf.String()
+	assert.NotContains(t, out, "---")
+	assert.Contains(t, out, "name: web.emojivoto.svc.cluster.local")
+}
+
+func TestClusterByTemplateKeepsLowTrafficSiblingsDistinct(t *testing.T) {
+	observations := []tapObservation{
+		{method: "GET", path: "/authors", status: 200},
+		{method: "GET", path: "/books", status: 200},
+	}
+
+	byTemplate := clusterByTemplate(observations)
+	require.Len(t, byTemplate, 2, "two distinct single-hit routes should not collapse into one wildcard")
+	assert.Contains(t, byTemplate, "^/authors$")
+	assert.Contains(t, byTemplate, "^/books$")
+}
+
+func TestClusterByTemplateCollapsesHighCardinalitySegment(t *testing.T) {
+	observations := make([]tapObservation, 0)
+	for i := 0; i < 10; i++ {
+		observations = append(observations, tapObservation{
+			method: "GET",
+			path:   fmt.Sprintf("/users/%d", i),
+			status: 200,
+		})
+	}
+
+	byTemplate := clusterByTemplate(observations)
+	require.Len(t, byTemplate, 1)
+	for template, obsForTemplate := range byTemplate {
+		assert.Equal(t, "^/users/[^/]*$", template)
+		assert.Len(t, obsForTemplate, 10)
+	}
+}
+
+func TestIsHighCardinality(t *testing.T) {
+	assert.False(t, isHighCardinality(2, 2), "a handful of distinct literal routes seen once each is not high cardinality")
+	assert.False(t, isHighCardinality(4, 4), "below the minimum sample size, the ratio check should not apply")
+	assert.True(t, isHighCardinality(10, 10), "above the minimum sample size, all-distinct values are high cardinality")
+	assert.False(t, isHighCardinality(2, 10), "low cardinality relative to sample size is not high cardinality")
+}
+
+func TestToRspClassesFromStatuses(t *testing.T) {
+	classes := toRspClassesFromStatuses([]int{200, 201, 404, 404, 503})
+	require.Len(t, classes, 3)
+	assert.Equal(t, uint32(200), classes[0].Condition.Status.Min)
+	assert.False(t, classes[0].IsFailure)
+	assert.Equal(t, uint32(400), classes[1].Condition.Status.Min)
+	assert.False(t, classes[1].IsFailure)
+	assert.Equal(t, uint32(500), classes[2].Condition.Status.Min)
+	assert.True(t, classes[2].IsFailure, "5xx ranges should be marked as failures")
+}
+
+func TestHttpMethodString(t *testing.T) {
+	assert.Equal(t, "", httpMethodString(nil))
+}
+
+func TestToTapObservationCorrelatesRequestAndResponse(t *testing.T) {
+	pending := make(map[string]pendingTapRequest)
+	id := &pb.TapEvent_Http_StreamId{Base: 1, Stream: 2}
+
+	reqEvent := &pb.TapEvent{
+		Event: &pb.TapEvent_Http_{
+			Http: &pb.TapEvent_Http{
+				Event: &pb.TapEvent_Http_RequestInit_{
+					RequestInit: &pb.TapEvent_Http_RequestInit{
+						Id:     id,
+						Path:   "/authors",
+						Method: &pb.HttpMethod{Type: &pb.HttpMethod_Registered_{Registered: pb.HttpMethod_GET}},
+					},
+				},
+			},
+		},
+	}
+	_, ok := toTapObservation(reqEvent, pending)
+	assert.False(t, ok, "a RequestInit event alone should not yet produce an observation")
+	require.Len(t, pending, 1)
+
+	respEvent := &pb.TapEvent{
+		Event: &pb.TapEvent_Http_{
+			Http: &pb.TapEvent_Http{
+				Event: &pb.TapEvent_Http_ResponseInit_{
+					ResponseInit: &pb.TapEvent_Http_ResponseInit{
+						Id:         id,
+						HttpStatus: 200,
+					},
+				},
+			},
+		},
+	}
+	obs, ok := toTapObservation(respEvent, pending)
+	require.True(t, ok)
+	assert.Equal(t, "GET", obs.method)
+	assert.Equal(t, "/authors", obs.path)
+	assert.Equal(t, 200, obs.status)
+	assert.Empty(t, pending, "a matched request should be removed from pending")
+}
+
+func TestToTapObservationIgnoresResponseEndAndUnmatchedResponses(t *testing.T) {
+	pending := make(map[string]pendingTapRequest)
+
+	endEvent := &pb.TapEvent{
+		Event: &pb.TapEvent_Http_{
+			Http: &pb.TapEvent_Http{
+				Event: &pb.TapEvent_Http_ResponseEnd_{
+					ResponseEnd: &pb.TapEvent_Http_ResponseEnd{
+						Id: &pb.TapEvent_Http_StreamId{Base: 1, Stream: 1},
+					},
+				},
+			},
+		},
+	}
+	_, ok := toTapObservation(endEvent, pending)
+	assert.False(t, ok, "ResponseEnd carries no HTTP status and should never produce an observation")
+
+	unmatched := &pb.TapEvent{
+		Event: &pb.TapEvent_Http_{
+			Http: &pb.TapEvent_Http{
+				Event: &pb.TapEvent_Http_ResponseInit_{
+					ResponseInit: &pb.TapEvent_Http_ResponseInit{
+						Id:         &pb.TapEvent_Http_StreamId{Base: 9, Stream: 9},
+						HttpStatus: 500,
+					},
+				},
+			},
+		},
+	}
+	_, ok = toTapObservation(unmatched, pending)
+	assert.False(t, ok, "a ResponseInit with no pending RequestInit should be ignored")
+}
+
+func TestSvcReferenceRegex(t *testing.T) {
+	match := svcReferenceRegex.FindStringSubmatch("svc://emojivoto/web:80/swagger.json")
+	require.NotNil(t, match)
+	assert.Equal(t, "emojivoto", match[1])
+	assert.Equal(t, "web", match[2])
+	assert.Equal(t, "80", match[3])
+	assert.Equal(t, "/swagger.json", match[4])
+
+	match = svcReferenceRegex.FindStringSubmatch("svc://emojivoto/web:80")
+	require.NotNil(t, match)
+	assert.Equal(t, "", match[4], "path is optional and should be empty when omitted")
+
+	assert.Nil(t, svcReferenceRegex.FindStringSubmatch("https://emojivoto/web:80/swagger.json"))
+	assert.Nil(t, svcReferenceRegex.FindStringSubmatch("svc://emojivoto/web/swagger.json"), "port is required")
+}
+
+func TestGrpcRouteSpecNoPackage(t *testing.T) {
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{"greeter.proto": `
+syntax = "proto3";
+
+service Greeter {
+  rpc SayHello (HelloRequest) returns (HelloReply) {}
+}
+
+message HelloRequest {}
+message HelloReply {}
+`}),
+	}
+	fds, err := parser.ParseFiles("greeter.proto")
+	require.NoError(t, err)
+	svc := fds[0].GetServices()[0]
+	method := svc.GetMethods()[0]
+
+	route := grpcRouteSpec(svc, method)
+	assert.Equal(t, "/Greeter/SayHello", route.Name)
+	assert.Equal(t, "^/Greeter/SayHello$", route.Condition.PathRegex)
+}
+
+func TestGrpcRouteSpecWithPackage(t *testing.T) {
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{"greeter.proto": `
+syntax = "proto3";
+package greet.v1;
+
+service Greeter {
+  rpc SayHello (HelloRequest) returns (HelloReply) {}
+}
+
+message HelloRequest {}
+message HelloReply {}
+`}),
+	}
+	fds, err := parser.ParseFiles("greeter.proto")
+	require.NoError(t, err)
+	svc := fds[0].GetServices()[0]
+	method := svc.GetMethods()[0]
+
+	route := grpcRouteSpec(svc, method)
+	assert.Equal(t, "/greet.v1.Greeter/SayHello", route.Name)
+}
+
+func TestHttpRuleMethodAndTemplate(t *testing.T) {
+	get := &annotations.HttpRule{Pattern: &annotations.HttpRule_Get{Get: "/v1/messages/{id}"}}
+	method, template, ok := httpRuleMethodAndTemplate(get)
+	require.True(t, ok)
+	assert.Equal(t, http.MethodGet, method)
+	assert.Equal(t, "/v1/messages/{id}", template)
+
+	empty := &annotations.HttpRule{}
+	_, _, ok = httpRuleMethodAndTemplate(empty)
+	assert.False(t, ok, "a rule with no verb set should not produce a route")
+
+	emptyCustom := &annotations.HttpRule{Pattern: &annotations.HttpRule_Custom{Custom: &annotations.CustomHttpPattern{}}}
+	_, _, ok = httpRuleMethodAndTemplate(emptyCustom)
+	assert.False(t, ok, "a custom binding with no kind/path should not produce a route")
+}
+
+// TestRestRouteSpecsIncludesAdditionalBindings exercises restRouteSpec over
+// a rule's primary binding plus its additional_bindings the same way
+// restRouteSpecs does, since constructing a MethodDescriptor with a real
+// google.api.http extension set requires compiling against
+// google/api/annotations.proto.
+func TestRestRouteSpecsIncludesAdditionalBindings(t *testing.T) {
+	rule := &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{Get: "/v1/messages/{id}"},
+		AdditionalBindings: []*annotations.HttpRule{
+			{Pattern: &annotations.HttpRule_Get{Get: "/v1/legacy/messages/{id}"}},
+		},
+	}
+
+	routes := make([]string, 0)
+	for _, r := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+		if route, ok := restRouteSpec(r); ok {
+			routes = append(routes, route.Name)
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		"GET /v1/messages/{id}",
+		"GET /v1/legacy/messages/{id}",
+	}, routes)
+}